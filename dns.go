@@ -46,6 +46,8 @@ import (
 	"os"
 	"net"
 	"strconv"
+	"sync"
+	"time"
 )
 
 const (
@@ -57,10 +59,15 @@ const (
 
 // Error represents a DNS error
 type Error struct {
-	Error   string
-	Name    string
-	Server  net.Addr
-	Timeout bool
+	Error  string
+	Name   string
+	Server net.Addr
+	// Whether the error represents a timeout or a temporary
+	// condition. These mirror the semantics of net.Error and
+	// are normally copied from the underlying net.Error that
+	// triggered this Error, if any.
+	timeout   bool
+	temporary bool
 }
 
 func (e *Error) String() string {
@@ -70,6 +77,80 @@ func (e *Error) String() string {
 	return e.Error
 }
 
+// Timeout implements the net.Error interface.
+func (e *Error) Timeout() bool {
+	if e == nil {
+		return false
+	}
+	return e.timeout
+}
+
+// Temporary implements the net.Error interface.
+func (e *Error) Temporary() bool {
+	if e == nil {
+		return false
+	}
+	return e.temporary
+}
+
+// wrapError builds an *Error carrying message s, copying the
+// Timeout/Temporary semantics of err onto it when err is itself a
+// net.Error. Read, Write and SetTimeout use this so that replacing a raw
+// net.Error with additional context never loses the information a
+// caller's `err.(net.Error); e.Timeout()` check depends on.
+func wrapError(s string, server net.Addr, err os.Error) *Error {
+	e := &Error{Error: s, Server: server}
+	if err != nil {
+		e.Error = s + ": " + err.String()
+	}
+	if ne, ok := err.(net.Error); ok {
+		e.timeout = ne.Timeout()
+		e.temporary = ne.Temporary()
+	}
+	return e
+}
+
+// readErr wraps a Read failure with wrapError, except for os.EOF, which
+// is returned as-is since callers (streamLoop in particular) rely on it
+// as the sentinel for a cleanly closed connection.
+func readErr(s string, server net.Addr, err os.Error) os.Error {
+	if err == os.EOF {
+		return err
+	}
+	return wrapError(s, server, err)
+}
+
+// ErrId is returned by ReadMsg/Exchange when a reply's Id does not
+// match the Id of the request it is supposed to answer.
+var ErrId os.Error = &Error{Error: "id mismatch"}
+
+// ErrQuestion is returned by ReadMsg/Exchange when a reply's question
+// section does not match the question section of the request it is
+// supposed to answer.
+var ErrQuestion os.Error = &Error{Error: "question section mismatch"}
+
+// checkReply verifies that reply actually answers out: a matching Id,
+// the QR bit set, and an exactly matching question section. It guards
+// against spoofed or mis-routed replies being unpacked as if they were
+// legitimate.
+func checkReply(out, reply *Msg) os.Error {
+        if reply.MsgHdr.Id != out.MsgHdr.Id {
+                return ErrId
+        }
+        if !reply.MsgHdr.Response {
+                return &Error{Error: "QR bit not set in reply"}
+        }
+        if len(reply.Question) != len(out.Question) {
+                return ErrQuestion
+        }
+        for i, q := range out.Question {
+                if reply.Question[i] != q {
+                        return ErrQuestion
+                }
+        }
+        return nil
+}
+
 // A Conn is the lowest primative in this dns package.
 // A Conn holds both the UDP and TCP connection, but only one
 // can be active any given time. 
@@ -95,6 +176,19 @@ type Conn struct {
 	// Number of attempts to try to Read/Write from/to a
         // connection.
 	Attempts int
+
+        // If true, Exchange returns a truncated UDP reply as-is
+        // instead of transparently retrying over TCP.
+        NoTCPFallback bool
+
+        // UDPSize overrides the buffer size NewBuffer allocates for UDP
+        // reads. The zero value means DefaultMsgSize.
+        UDPSize int
+
+        // Set once SetReadDeadline/SetWriteDeadline has been called, so
+        // Write knows not to clobber an explicit deadline with its own
+        // blanket SetTimeout.
+        deadlineSet bool
 }
 
 // Create a new buffer of the appropiate size. With
@@ -106,26 +200,49 @@ func (d *Conn) NewBuffer() []byte {
                 return b
         }
         if d.UDP != nil {
-                b := make([]byte, DefaultMsgSize)
+                size := d.UDPSize
+                if size == 0 {
+                        size = DefaultMsgSize
+                }
+                b := make([]byte, size)
                 return b
         }
         return nil
 }
 
-// ReadMsg reads a dns message m from d.
+// ReadMsg reads a dns message into m from d.
+// If out is non-nil it is assumed to be the message that was last
+// written to d with WriteMsg; ReadMsg then checks m's Id and question
+// section against out, returning ErrId/ErrQuestion on a mismatch rather
+// than handing back an unrelated reply. On UDP a mismatch is treated as
+// a stray or duplicate packet: reading continues (bounded by Timeout)
+// until a genuine match arrives or the read itself times out; on TCP a
+// mismatch is returned immediately, since only one reply is expected on
+// the wire. Pass out as nil to skip the check entirely.
 // Any errors of the underlaying Read call are returned.
-func (d *Conn) ReadMsg(m *Msg) os.Error {
-        in := d.NewBuffer()
-        n, err := d.Read(in)
-        if err != nil {
-                return err
-        }
-        in = in[:n]
-        ok := m.Unpack(in)
-        if !ok {
-                return &Error{Error: "Failed to unpack"}
+func (d *Conn) ReadMsg(m *Msg, out *Msg) os.Error {
+        wasUDP := d.UDP != nil
+        for {
+                in := d.NewBuffer()
+                n, err := d.Read(in)
+                if err != nil {
+                        return err
+                }
+                in = in[:n]
+                if !m.Unpack(in) {
+                        return &Error{Error: "Failed to unpack"}
+                }
+                if out == nil {
+                        return nil
+                }
+                if verr := checkReply(out, m); verr != nil {
+                        if wasUDP {
+                                continue
+                        }
+                        return verr
+                }
+                return nil
         }
-        return nil
 }
 
 // WriteMsg writes dns message m to d.
@@ -145,6 +262,15 @@ func (d *Conn) WriteMsg(m *Msg) os.Error {
         return nil
 }
 
+// Truncated reports whether m has its TC (truncated) bit set, i.e.
+// whether m is a UDP reply that was cut short and should be re-fetched
+// over TCP. Conn.Exchange already does this automatically unless
+// NoTCPFallback is set; Truncated is exposed for callers that read
+// messages some other way and want to handle TC themselves.
+func (m *Msg) Truncated() bool {
+        return m.MsgHdr.Truncated
+}
+
 // Read implements the standard Read interface:
 // it reads from d. If there was an error
 // reading that error is returned; otherwise err is nil.
@@ -157,7 +283,7 @@ func (d *Conn) Read(p []byte) (n int, err os.Error) {
                 var addr net.Addr
 		n, addr, err = d.UDP.ReadFromUDP(p)
 		if err != nil {
-			return n, err
+			return n, readErr("udp read failed", d.Addr, err)
 		}
                 d.Addr = addr
                 d.Port = addr.(*net.UDPAddr).Port
@@ -167,7 +293,7 @@ func (d *Conn) Read(p []byte) (n int, err os.Error) {
                 }
 		n, err = d.TCP.Read(p[0:2])
 		if err != nil || n != 2 {
-			return n, err
+			return n, readErr("tcp read failed", d.Addr, err)
 		}
                 d.Addr = d.TCP.RemoteAddr()
                 d.Port = d.TCP.RemoteAddr().(*net.TCPAddr).Port
@@ -180,13 +306,13 @@ func (d *Conn) Read(p []byte) (n int, err os.Error) {
 		}
 		n, err = d.TCP.Read(p)
 		if err != nil {
-			return n, err
+			return n, readErr("tcp read failed", d.Addr, err)
 		}
 		i := n
 		for i < int(l) {
 			n, err = d.TCP.Read(p[i:])
 			if err != nil {
-				return i, err
+				return i, readErr("tcp read failed", d.Addr, err)
 			}
 			i += n
 		}
@@ -217,7 +343,9 @@ func (d *Conn) Write(p []byte) (n int, err os.Error) {
 	} else {
 		attempts = d.Attempts
 	}
-	d.SetTimeout()
+        if !d.deadlineSet {
+                d.SetTimeout()
+        }
         if d.Tsig != nil {
                 // Create a new buffer with the TSIG added.
                 q, err = d.Tsig.Generate(p)
@@ -236,7 +364,7 @@ func (d *Conn) Write(p []byte) (n int, err os.Error) {
 				if e, ok := err.(net.Error); ok && e.Timeout() {
 					continue
 				}
-				return 0, err
+				return 0, wrapError("udp write failed", d.Addr, err)
 			}
 		}
 	case d.TCP != nil:
@@ -248,7 +376,7 @@ func (d *Conn) Write(p []byte) (n int, err os.Error) {
 				if e, ok := err.(net.Error); ok && e.Timeout() {
 					continue
 				}
-				return n, err
+				return n, wrapError("tcp write failed", d.Addr, err)
 			}
 			if n != 2 {
 				return n, &Error{Error: "Write failure"}
@@ -258,7 +386,7 @@ func (d *Conn) Write(p []byte) (n int, err os.Error) {
 				if e, ok := err.(net.Error); ok && e.Timeout() {
 					continue
 				}
-				return n, err
+				return n, wrapError("tcp write failed", d.Addr, err)
 			}
                         i := n
                         if i < len(q) {
@@ -268,7 +396,7 @@ func (d *Conn) Write(p []byte) (n int, err os.Error) {
                                                 // We are half way in our write...
 					        continue
 				        }
-				        return n, err
+				        return n, wrapError("tcp write failed", d.Addr, err)
                                 }
                                 i += n
 			}
@@ -305,34 +433,428 @@ func (d *Conn) SetTimeout() (err os.Error) {
 		sec = 1
 	}
 	if d.UDP != nil {
-		err = d.TCP.SetTimeout(sec * 1e9)
+		err = d.UDP.SetTimeout(sec * 1e9)
 	}
 	if d.TCP != nil {
 		err = d.TCP.SetTimeout(sec * 1e9)
 	}
+	if err != nil {
+		err = wrapError("failed to set timeout", d.Addr, err)
+	}
 	return
 }
 
+// SetReadDeadline sets an absolute deadline for the next Read on d,
+// mirroring net.Conn.SetReadDeadline. Unlike SetTimeout, which rearms a
+// blanket idle timeout on every call, this lets a caller (Client, in
+// particular) bound a single Read precisely instead of the connection
+// as a whole; once called, Write no longer overrides the deadline with
+// its own call to SetTimeout.
+func (d *Conn) SetReadDeadline(t time.Time) os.Error {
+        if d.UDP != nil && d.TCP != nil {
+                return &Error{Error: "UDP and TCP or both non-nil"}
+        }
+        d.deadlineSet = true
+        switch {
+        case d.UDP != nil:
+                return d.UDP.SetReadDeadline(t)
+        case d.TCP != nil:
+                return d.TCP.SetReadDeadline(t)
+        }
+        return nil
+}
+
+// SetWriteDeadline sets an absolute deadline for the next Write on d;
+// see SetReadDeadline.
+func (d *Conn) SetWriteDeadline(t time.Time) os.Error {
+        if d.UDP != nil && d.TCP != nil {
+                return &Error{Error: "UDP and TCP or both non-nil"}
+        }
+        d.deadlineSet = true
+        switch {
+        case d.UDP != nil:
+                return d.UDP.SetWriteDeadline(t)
+        case d.TCP != nil:
+                return d.TCP.SetWriteDeadline(t)
+        }
+        return nil
+}
+
 // Exchange combines a Write and a Read.
 // First the request is written to d and then it waits
-// for a reply with Read. 
+// for a reply with Read.
 // If nosend is true, the write is skipped.
-func (d *Conn) Exchange(request []byte, nosend bool) (reply []byte, err os.Error) {
-	var n int
+// If out is non-nil it is assumed to be the message that request was
+// packed from; Exchange then checks the reply's Id and question section
+// against out, returning ErrId/ErrQuestion on a mismatch instead of
+// handing back an unrelated reply. On UDP a mismatch is treated as a
+// stray or duplicate packet: reading continues (bounded by Timeout)
+// until a genuine match arrives or the read itself times out; on TCP a
+// mismatch is fatal, since only one reply is expected on the wire. Pass
+// out as nil to skip the check entirely.
+// If d is a UDP connection and the reply comes back with the TC (truncated)
+// bit set, Exchange transparently dials TCP to the same Addr/Port, resends
+// request and returns the complete reply instead, unless d.NoTCPFallback
+// is set.
+func (d *Conn) Exchange(request []byte, out *Msg, nosend bool) (reply []byte, err os.Error) {
+	wasUDP := d.UDP != nil
         if !nosend {
-                n, err = d.Write(request)
+                _, err = d.Write(request)
                 if err != nil {
                         return nil, err
                 }
         }
-	// Layer violation to save memory. Its okay then...
-        reply = d.NewBuffer()
-	n, err = d.Read(reply)
-	if err != nil {
-		return nil, err
-	}
-	reply = reply[:n]
-	return
+        for {
+	        // Layer violation to save memory. Its okay then...
+                reply = d.NewBuffer()
+	        n, err := d.Read(reply)
+	        if err != nil {
+		        return nil, err
+	        }
+	        reply = reply[:n]
+                if out != nil {
+                        m := new(Msg)
+                        if !m.Unpack(reply) {
+                                return nil, &Error{Error: "Failed to unpack"}
+                        }
+                        if verr := checkReply(out, m); verr != nil {
+                                if wasUDP {
+                                        continue
+                                }
+                                return nil, verr
+                        }
+                }
+                break
+        }
+        if wasUDP && !d.NoTCPFallback && truncated(reply) {
+                return d.exchangeTCPFallback(request, out, reply)
+        }
+	return reply, nil
+}
+
+// exchangeTCPFallback re-sends request over a freshly dialed TCP
+// connection to the same Addr/Port as d, after a UDP reply to Exchange
+// came back truncated. It reuses d's Tsig, Timeout and Attempts so
+// signed messages remain verifiable and retry policy stays consistent,
+// and threads out through so the TCP reply is validated the same way.
+func (d *Conn) exchangeTCPFallback(request []byte, out *Msg, udpReply []byte) (reply []byte, err os.Error) {
+        addr, ok := d.Addr.(*net.UDPAddr)
+        if !ok {
+                return udpReply, &Error{Error: "cannot fall back to TCP: no UDP peer address", Server: d.Addr}
+        }
+        tcp, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: addr.IP, Port: d.Port})
+        if err != nil {
+                return udpReply, err
+        }
+        t := &Conn{
+                TCP:           tcp,
+                Port:          d.Port,
+                Tsig:          d.Tsig,
+                Timeout:       d.Timeout,
+                Attempts:      d.Attempts,
+                NoTCPFallback: true, // already on TCP, never recurse
+        }
+        defer t.Close()
+        reply, err = t.Exchange(request, out, false)
+        if err != nil {
+                return udpReply, err
+        }
+        if truncated(reply) {
+                return reply, &Error{Error: "reply truncated over TCP fallback as well", Server: d.Addr}
+        }
+        return reply, nil
+}
+
+// truncated reports whether the TC bit is set in the header of a raw
+// wire-format DNS message. It is checked directly on the wire bytes, so
+// Exchange can test a reply for TC without unpacking it when out is nil;
+// see Msg.Truncated for the decoded-message equivalent.
+func truncated(msg []byte) bool {
+        if len(msg) < 3 {
+                return false
+        }
+        return msg[2]&0x02 != 0
+}
+
+// A StreamResult is a single envelope delivered by Conn.ExchangeStream:
+// either a decoded reply, or, on the final envelope before the channel
+// closes early, the error that ended the stream.
+type StreamResult struct {
+        Msg *Msg
+        Err os.Error
+}
+
+// ExchangeStream keeps d's TCP connection open, writes request once and
+// returns a channel fed one decoded reply at a time until the stream
+// ends. If out is non-nil it is the message request was packed from:
+// the first envelope's Id is checked against it, and its question type
+// decides whether to expect a single reply or an AXFR/IXFR-style
+// sequence bounded by a closing SOA. Call d.Close when done with the
+// stream; the channel is closed for you once it ends.
+//
+// d.Tsig is not supported here: sparse TSIG per RFC 2845 (only every
+// 100th message signed) needs MAC chaining across messages that Conn's
+// single-message Tsig.Verify can't do, so a signed Conn is rejected
+// outright rather than failing partway through a transfer.
+func (d *Conn) ExchangeStream(request []byte, out *Msg) (chan *StreamResult, os.Error) {
+        if d.TCP == nil {
+                return nil, &Error{Error: "ExchangeStream requires a TCP connection"}
+        }
+        if d.Tsig != nil {
+                return nil, &Error{Error: "ExchangeStream does not support TSIG-signed streams"}
+        }
+        if _, err := d.Write(request); err != nil {
+                return nil, err
+        }
+        var qtype uint16
+        if out != nil && len(out.Question) > 0 {
+                qtype = out.Question[0].Qtype
+        }
+        ch := make(chan *StreamResult, 1)
+        go d.streamLoop(ch, out, qtype)
+        return ch, nil
+}
+
+// streamLoop is the body of the goroutine started by ExchangeStream. It
+// reuses Read's existing 2-byte length framing to pull one message at a
+// time off the wire. qtype (the outgoing question's type, zero if
+// unknown) decides whether to expect an AXFR/IXFR-style sequence bounded
+// by a closing SOA, or a single reply.
+func (d *Conn) streamLoop(ch chan *StreamResult, out *Msg, qtype uint16) {
+        defer close(ch)
+        multi := qtype == TypeAXFR || qtype == TypeIXFR
+        soa := 0
+        msgCount := 0
+        for {
+                in := d.NewBuffer()
+                n, err := d.Read(in)
+                if err != nil {
+                        if err != os.EOF {
+                                ch <- &StreamResult{Err: err}
+                        }
+                        return
+                }
+                in = in[:n]
+                m := new(Msg)
+                if !m.Unpack(in) {
+                        ch <- &StreamResult{Err: &Error{Error: "Failed to unpack"}}
+                        return
+                }
+                if msgCount == 0 && out != nil && m.MsgHdr.Id != out.MsgHdr.Id {
+                        ch <- &StreamResult{Err: ErrId}
+                        return
+                }
+                msgCount++
+
+                if !multi {
+                        // A plain query or a bulk SOA probe: one reply
+                        // completes the exchange.
+                        ch <- &StreamResult{Msg: m}
+                        return
+                }
+
+                for _, rr := range m.Answer {
+                        if rr.Header().Rrtype == TypeSOA {
+                                soa++
+                        }
+                }
+                if qtype == TypeIXFR && msgCount == 1 && len(m.Answer) == 1 && soa == 1 {
+                        // RFC 1995: a single-message IXFR response
+                        // carrying just one SOA means the zone is
+                        // unchanged; there is no transfer to follow.
+                        ch <- &StreamResult{Msg: m}
+                        return
+                }
+                ch <- &StreamResult{Msg: m}
+                if soa >= 2 {
+                        return
+                }
+        }
+}
+
+// A Client provides connection reuse, a concurrency-safe Exchange and
+// per-call deadlines for sending many queries, in contrast to Conn,
+// which mixes transport state (the UDP/TCP socket), per-call state
+// (Addr, Port, Tsig) and policy (Timeout, Attempts) and so cannot safely
+// be shared across goroutines or reused between queries. A Client plays
+// the role http.Client plays for HTTP: one value, shared by many
+// goroutines, each Exchange call getting its own deadline and, for TCP,
+// a connection already open to address where one exists.
+// The zero value is a usable Client that dials plain UDP with no
+// timeouts.
+type Client struct {
+        // Net is the network used to dial new connections: "udp" or
+        // "tcp". The zero value means "udp". "tcp-tls" is not supported:
+        // Exchange returns an error for it without sending anything.
+        Net string
+
+        // DialTimeout bounds how long dialing a new connection may take.
+        // The zero value means no timeout.
+        DialTimeout time.Duration
+
+        // ReadTimeout and WriteTimeout bound the reading and the writing
+        // done by one Exchange call; each deadline is measured from the
+        // start of that call, before dialing. The zero value means no
+        // timeout.
+        ReadTimeout  time.Duration
+        WriteTimeout time.Duration
+
+        // UDPSize is the size of the buffer used to read UDP replies.
+        // The zero value means DefaultMsgSize.
+        UDPSize int
+
+        // TsigSecret maps a TSIG key name to its base64-encoded secret.
+        // If m carries a TSIG RR in its Extra section, Exchange looks up
+        // its owner name here to sign the request and verify the reply.
+        TsigSecret map[string]string
+
+        mu    sync.Mutex
+        tconn map[string]*net.TCPConn // address -> an open, idle TCP connection
+}
+
+// Exchange sends m to address and waits for the reply, returning the
+// round-trip time alongside it. It is safe for concurrent use: many
+// goroutines can share one Client value the way they share one
+// http.Client, each call getting its own deadline and, for TCP, reusing
+// a connection already open to address instead of contending for Conn's
+// single UDP/TCP pair.
+func (c *Client) Exchange(m *Msg, address string) (r *Msg, rtt time.Duration, err os.Error) {
+        request, ok := m.Pack()
+        if !ok {
+                return nil, 0, &Error{Error: "Failed to pack", Name: address}
+        }
+
+        network := c.Net
+        if network == "" {
+                network = "udp"
+        }
+
+        start := time.Now()
+        d, err := c.dial(network, address)
+        if err != nil {
+                return nil, 0, err
+        }
+
+        // Set both deadlines unconditionally, even when the corresponding
+        // Timeout field is its zero value (which SetWriteDeadline/
+        // SetReadDeadline treat as "no deadline", same as net.Conn).
+        // This is what marks d as deadline-managed, so Conn.Write never
+        // falls back to its own blanket SetTimeout and silently
+        // overrides what Client configured.
+        var writeDeadline, readDeadline time.Time
+        if c.WriteTimeout != 0 {
+                writeDeadline = start.Add(c.WriteTimeout)
+        }
+        if err = d.SetWriteDeadline(writeDeadline); err != nil {
+                d.Close()
+                return nil, 0, err
+        }
+        if c.ReadTimeout != 0 {
+                readDeadline = start.Add(c.ReadTimeout)
+        }
+        if err = d.SetReadDeadline(readDeadline); err != nil {
+                d.Close()
+                return nil, 0, err
+        }
+        d.Tsig = c.tsigFor(m)
+
+        reply, err := d.Exchange(request, m, false)
+        rtt = time.Now().Sub(start)
+        if err != nil {
+                d.Close()
+                return nil, rtt, err
+        }
+
+        if network == "udp" {
+                d.Close()
+        } else {
+                c.release(address, d)
+        }
+
+        r = new(Msg)
+        if !r.Unpack(reply) {
+                return nil, rtt, &Error{Error: "Failed to unpack", Name: address}
+        }
+        return r, rtt, nil
+}
+
+// dial returns a Conn ready to Exchange over network to address,
+// reusing an already-open TCP connection from a previous Exchange when
+// one is available instead of dialing a new one.
+func (c *Client) dial(network, address string) (d *Conn, err os.Error) {
+        if network == "tcp" {
+                c.mu.Lock()
+                tcp, ok := c.tconn[address]
+                if ok {
+                        delete(c.tconn, address)
+                }
+                c.mu.Unlock()
+                if ok {
+                        return &Conn{TCP: tcp, Addr: tcp.RemoteAddr(), Port: tcp.RemoteAddr().(*net.TCPAddr).Port}, nil
+                }
+        }
+
+        if network == "tcp-tls" {
+                // Wrapping the dialed connection in TLS needs the
+                // crypto/tls package, which isn't part of this chunk, so
+                // a caller asking for "tcp-tls" gets an explicit error
+                // rather than queries silently going out over plaintext
+                // TCP instead.
+                return nil, &Error{Error: "tcp-tls is not supported in this chunk of the package", Name: address}
+        }
+        conn, err := net.DialTimeout(network, address, c.DialTimeout)
+        if err != nil {
+                return nil, err
+        }
+        switch t := conn.(type) {
+        case *net.TCPConn:
+                return &Conn{TCP: t, Addr: t.RemoteAddr(), Port: t.RemoteAddr().(*net.TCPAddr).Port}, nil
+        case *net.UDPConn:
+                return &Conn{UDP: t, Addr: t.RemoteAddr(), Port: t.RemoteAddr().(*net.UDPAddr).Port, UDPSize: c.UDPSize}, nil
+        }
+        return nil, &Error{Error: "unsupported network: " + network}
+}
+
+// release returns d's TCP connection to the pool so a later Exchange to
+// the same address can reuse it instead of dialing again. Only one idle
+// connection is kept per address; if a concurrent Exchange already
+// returned one for address, that one wins the slot and d's connection is
+// closed instead of being silently dropped and leaked.
+func (c *Client) release(address string, d *Conn) {
+        if d.TCP == nil {
+                return
+        }
+        c.mu.Lock()
+        if c.tconn == nil {
+                c.tconn = make(map[string]*net.TCPConn)
+        }
+        _, busy := c.tconn[address]
+        if !busy {
+                c.tconn[address] = d.TCP
+        }
+        c.mu.Unlock()
+        if busy {
+                d.Close()
+        }
+}
+
+// tsigFor builds the Tsig state needed to sign the request and verify
+// the reply, if m carries a TSIG RR in its Extra section naming a key
+// present in TsigSecret.
+func (c *Client) tsigFor(m *Msg) *Tsig {
+        if len(c.TsigSecret) == 0 {
+                return nil
+        }
+        for _, rr := range m.Extra {
+                if rr.Header().Rrtype != TypeTSIG {
+                        continue
+                }
+                name := rr.Header().Name
+                if secret, ok := c.TsigSecret[name]; ok {
+                        return &Tsig{Name: name, Secret: secret}
+                }
+        }
+        return nil
 }
 
 type RR interface {
@@ -0,0 +1,150 @@
+package dns
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+// fakeNetError is a minimal net.Error, used so wrapError can be tested
+// without needing a real socket to time out.
+type fakeNetError struct {
+	timeout, temporary bool
+}
+
+func (e *fakeNetError) String() string  { return "fake net error" }
+func (e *fakeNetError) Timeout() bool   { return e.timeout }
+func (e *fakeNetError) Temporary() bool { return e.temporary }
+
+func TestErrorImplementsNetError(t *testing.T) {
+	underlying := &fakeNetError{timeout: true, temporary: true}
+	var wrapped os.Error = wrapError("read failed", nil, underlying)
+
+	ne, ok := wrapped.(net.Error)
+	if !ok {
+		t.Fatalf("*Error does not implement net.Error")
+	}
+	if !ne.Timeout() {
+		t.Errorf("Timeout() = false, want true")
+	}
+	if !ne.Temporary() {
+		t.Errorf("Temporary() = false, want true")
+	}
+}
+
+func TestErrorNotTimeoutByDefault(t *testing.T) {
+	e := &Error{Error: "some failure"}
+	if e.Timeout() {
+		t.Errorf("Timeout() = true for a plain Error, want false")
+	}
+	if e.Temporary() {
+		t.Errorf("Temporary() = true for a plain Error, want false")
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	tests := []struct {
+		msg  []byte
+		want bool
+	}{
+		{[]byte{0, 0, 0x02}, true},
+		{[]byte{0, 0, 0x00}, false},
+		{[]byte{0, 0, 0x03}, true},
+		{[]byte{0, 0}, false},
+		{[]byte{}, false},
+	}
+	for _, tt := range tests {
+		if got := truncated(tt.msg); got != tt.want {
+			t.Errorf("truncated(%v) = %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestCheckReply(t *testing.T) {
+	out := new(Msg)
+	out.MsgHdr.Id = 42
+	out.Question = make([]Question, 1)
+	out.Question[0] = Question{"miek.nl", TypeSOA, ClassINET}
+
+	reply := new(Msg)
+	reply.MsgHdr.Id = 42
+	reply.MsgHdr.Response = true
+	reply.Question = out.Question
+
+	if err := checkReply(out, reply); err != nil {
+		t.Fatalf("checkReply() = %v, want nil for a matching reply", err)
+	}
+
+	idMismatch := new(Msg)
+	*idMismatch = *reply
+	idMismatch.MsgHdr.Id = 43
+	if err := checkReply(out, idMismatch); err != ErrId {
+		t.Errorf("checkReply() with mismatched Id = %v, want ErrId", err)
+	}
+
+	notResponse := new(Msg)
+	*notResponse = *reply
+	notResponse.MsgHdr.Response = false
+	if err := checkReply(out, notResponse); err == nil {
+		t.Errorf("checkReply() with QR bit unset = nil, want an error")
+	}
+
+	wrongQuestion := new(Msg)
+	*wrongQuestion = *reply
+	wrongQuestion.Question = []Question{{"example.com", TypeSOA, ClassINET}}
+	if err := checkReply(out, wrongQuestion); err != ErrQuestion {
+		t.Errorf("checkReply() with mismatched question = %v, want ErrQuestion", err)
+	}
+}
+
+// dialTCP returns a fresh, connected *net.TCPConn to ln, for exercising
+// Client's connection pool without a real DNS server.
+func dialTCP(t *testing.T, ln net.Listener) *net.TCPConn {
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	return conn.(*net.TCPConn)
+}
+
+func TestClientReleaseAndDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+	addr := ln.Addr().String()
+
+	c := new(Client)
+
+	first := &Conn{TCP: dialTCP(t, ln)}
+	c.release(addr, first)
+	if got := c.tconn[addr]; got != first.TCP {
+		t.Fatalf("release() did not pool the first connection")
+	}
+
+	// dial should pop the pooled connection and leave the pool empty.
+	d, err := c.dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial() = %v, want nil", err)
+	}
+	if d.TCP != first.TCP {
+		t.Errorf("dial() returned a different connection than was pooled")
+	}
+	if _, ok := c.tconn[addr]; ok {
+		t.Errorf("dial() left the reused connection in the pool")
+	}
+
+	// Releasing two connections for the same address at once must not
+	// leak the loser: it should be closed rather than dropped.
+	winner := &Conn{TCP: dialTCP(t, ln)}
+	loser := &Conn{TCP: dialTCP(t, ln)}
+	c.release(addr, winner)
+	c.release(addr, loser)
+	if got := c.tconn[addr]; got != winner.TCP {
+		t.Errorf("release() did not keep the first pooled connection")
+	}
+	if err := loser.TCP.SetTimeout(0); err == nil {
+		t.Errorf("release() did not close the evicted connection")
+	}
+}